@@ -20,17 +20,184 @@ import (
 	"fmt"
 	"reflect"
 	"sort"
+	"strconv"
 	"strings"
+	"sync"
 
 	"github.com/golang/glog"
+	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/kops/upup/pkg/fi/utils"
 )
 
-// BuildFlags builds flag arguments based on "flag" tags on the structure
+// Encoder renders a single field's value to the string that goes on the right-hand
+// side of "--<name>=", or "" if no flag should be emitted for it. tag is the full
+// struct tag for the field, so an encoder can consult its own tag options (as the
+// built-in string, numeric, Duration and Quantity encoders do with "flag-empty").
+type Encoder func(name string, v reflect.Value, tag reflect.StructTag) (string, error)
+
+// defaultEncoders holds the built-in encoders, keyed by the concrete reflect.Type
+// they handle. RegisterFlagEncoder adds to this set so that other kops packages can
+// teach BuildFlags about their own option types without editing this file.
+var (
+	defaultEncodersMu sync.RWMutex
+	defaultEncoders   = map[reflect.Type]Encoder{
+		reflect.TypeOf(""):                     encodeString,
+		reflect.TypeOf(bool(false)):            encodeSimpleValue,
+		reflect.TypeOf(int(0)):                 encodeSimpleValue,
+		reflect.TypeOf(int32(0)):               encodeSimpleValue,
+		reflect.TypeOf(int64(0)):               encodeSimpleValue,
+		reflect.TypeOf(float32(0)):             encodeSimpleValue,
+		reflect.TypeOf(float64(0)):             encodeSimpleValue,
+		reflect.TypeOf(metav1.Duration{}):      encodeDuration,
+		reflect.TypeOf(resource.Quantity{}):    encodeQuantity,
+		reflect.TypeOf(map[string]string(nil)): encodeStringStringMap,
+		reflect.TypeOf(map[string]bool(nil)):   encodeStringBoolMap,
+		reflect.TypeOf([]string(nil)):          encodeStringSlice,
+	}
+)
+
+// RegisterFlagEncoder registers an Encoder for t, used by every subsequent call to
+// BuildFlags and BuildFlagsList. Packages that add option fields of a type not
+// already understood here (IP ranges, net.IP, intstr.IntOrString, etc.) should call
+// this from an init() rather than editing this file.
+func RegisterFlagEncoder(t reflect.Type, fn Encoder) {
+	defaultEncodersMu.Lock()
+	defer defaultEncodersMu.Unlock()
+	defaultEncoders[t] = fn
+}
+
+func encodeString(name string, v reflect.Value, tag reflect.StructTag) (string, error) {
+	s := v.String()
+	if s == "" || s == tag.Get("flag-empty") {
+		return "", nil
+	}
+	return s, nil
+}
+
+func encodeSimpleValue(name string, v reflect.Value, tag reflect.StructTag) (string, error) {
+	s := fmt.Sprintf("%v", v.Interface())
+	if s == tag.Get("flag-empty") {
+		return "", nil
+	}
+	return s, nil
+}
+
+func encodeDuration(name string, v reflect.Value, tag reflect.StructTag) (string, error) {
+	d := v.Interface().(metav1.Duration)
+	s := d.Duration.String()
+
+	// See https://github.com/kubernetes/kubernetes/issues/40783
+	// Go renders a time.Duration to `0` in <= 1.6, and `0s` in >= 1.7
+	// We force it to be `0s`, regardless of value
+	if s == "0" {
+		s = "0s"
+	}
+
+	if s == tag.Get("flag-empty") {
+		return "", nil
+	}
+	return s, nil
+}
+
+func encodeQuantity(name string, v reflect.Value, tag reflect.StructTag) (string, error) {
+	q := v.Interface().(resource.Quantity)
+	s := q.String()
+	if s == tag.Get("flag-empty") {
+		return "", nil
+	}
+	return s, nil
+}
+
+// encodeStringStringMap handles a map[string]string like --node-labels=k1=v1,k2=v2 etc.
+// As we need more formats we can add additional spec to the flags tag.
+func encodeStringStringMap(name string, v reflect.Value, tag reflect.StructTag) (string, error) {
+	m := v.Interface().(map[string]string)
+	if len(m) == 0 {
+		return "", nil
+	}
+
+	var keys []string
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var args []string
+	for _, k := range keys {
+		args = append(args, fmt.Sprintf("%s=%s", k, m[k]))
+	}
+	return strings.Join(args, ","), nil
+}
+
+// encodeStringBoolMap handles a map[string]bool like --feature-gates=Key1=true,Key2=false etc.
+// flag-format is validated centrally by the walker, so by the time an encoder runs
+// it is already one of the allow-listed values.
+func encodeStringBoolMap(name string, v reflect.Value, tag reflect.StructTag) (string, error) {
+	m := v.Interface().(map[string]bool)
+	if len(m) == 0 {
+		return "", nil
+	}
+
+	var keys []string
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var args []string
+	for _, k := range keys {
+		args = append(args, fmt.Sprintf("%s=%t", k, m[k]))
+	}
+	return strings.Join(args, ","), nil
+}
+
+// encodeStringSlice handles a []string like --admission-control=v1,v2 etc.
+// Order is significant for some flags (e.g. admission-control plugin ordering),
+// so the caller-supplied order is preserved rather than sorted.
+func encodeStringSlice(name string, v reflect.Value, tag reflect.StructTag) (string, error) {
+	s := v.Interface().([]string)
+	if len(s) == 0 {
+		return "", nil
+	}
+	return strings.Join(s, ","), nil
+}
+
+// BuildFlags builds flag arguments based on "flag" tags on the structure,
+// joining the individual arguments with spaces
 func BuildFlags(options interface{}) (string, error) {
+	flags, err := BuildFlagsList(options)
+	if err != nil {
+		return "", err
+	}
+	return strings.Join(flags, " "), nil
+}
+
+// BuildFlagsList builds flag arguments based on "flag" tags on the structure,
+// returning the individual "--k=v" tokens rather than a single joined string.
+// This is useful for callers that pass the arguments to exec.Command (or similar)
+// without going through a shell, where joining and re-splitting on spaces is unsafe.
+func BuildFlagsList(options interface{}) ([]string, error) {
+	return BuildFlagsWithEncoders(options, nil)
+}
+
+// BuildFlagsWithEncoders is like BuildFlagsList, but also consults extra for a
+// per-call encoder override before falling back to the default/registered
+// encoders. This lets tests inject encoders for a call without mutating the
+// global registry via RegisterFlagEncoder.
+func BuildFlagsWithEncoders(options interface{}, extra map[reflect.Type]Encoder) ([]string, error) {
 	var flags []string
 
+	encoderFor := func(t reflect.Type) (Encoder, bool) {
+		if fn, ok := extra[t]; ok {
+			return fn, true
+		}
+		defaultEncodersMu.RLock()
+		defer defaultEncodersMu.RUnlock()
+		fn, ok := defaultEncoders[t]
+		return fn, ok
+	}
+
 	walker := func(path string, field *reflect.StructField, val reflect.Value) error {
 		if field == nil {
 			glog.V(8).Infof("ignoring non-field: %s", path)
@@ -48,9 +215,6 @@ func BuildFlags(options interface{}) (string, error) {
 		}
 		flagName := tag
 
-		// If the "unset" value is not empty string, by setting this tag we avoid passing spurious flag values
-		flagEmpty := field.Tag.Get("flag-empty")
-
 		// We do have to do this, even though the recursive walk will do it for us
 		// because when we descend we won't have `field` set
 		if val.Kind() == reflect.Ptr {
@@ -60,87 +224,76 @@ func BuildFlags(options interface{}) (string, error) {
 			val = val.Elem()
 		}
 
-		if val.Kind() == reflect.Map {
+		if val.Kind() == reflect.Map || val.Kind() == reflect.Slice {
 			if val.IsNil() {
 				return nil
 			}
-			// We handle a map[string]string like --node-labels=k1=v1,k2=v2 etc
-			// As we need more formats we can add additional spec to the flags tag
-			if stringStringMap, ok := val.Interface().(map[string]string); ok {
-				var args []string
-				for k, v := range stringStringMap {
-					arg := fmt.Sprintf("%s=%s", k, v)
-					args = append(args, arg)
-				}
-				if len(args) != 0 {
-					flag := fmt.Sprintf("--%s=%s", flagName, strings.Join(args, ","))
-					flags = append(flags, flag)
-				}
-				return utils.SkipReflection
-			} else {
-				return fmt.Errorf("BuildFlags of value type not handled: %T %s=%v", val.Interface(), path, val.Interface())
-			}
 		}
 
-		if val.Kind() == reflect.Slice {
-			if val.IsNil() {
-				return nil
-			}
-			// We handle a []string like --admission-control=v1,v2 etc
-			if stringSlice, ok := val.Interface().([]string); ok {
-				if len(stringSlice) != 0 {
-					flag := fmt.Sprintf("--%s=%s", flagName, strings.Join(stringSlice, ","))
-					flags = append(flags, flag)
-				}
-				return utils.SkipReflection
-			} else {
-				return fmt.Errorf("BuildFlags of value type not handled: %T %s=%v", val.Interface(), path, val.Interface())
-			}
+		// flag-format is validated here, for every type, so a typo (e.g. "repeet")
+		// errors instead of silently falling through to the default encoding.
+		flagFormat := field.Tag.Get("flag-format")
+		switch flagFormat {
+		case "", "keyvalue", "repeat":
+		default:
+			return fmt.Errorf("BuildFlags: unknown flag-format %q: %s", flagFormat, path)
 		}
 
-		var flag string
-		switch v := val.Interface().(type) {
-		case string:
-			vString := fmt.Sprintf("%v", v)
-			if vString != "" && vString != flagEmpty {
-				flag = fmt.Sprintf("--%s=%s", flagName, vString)
+		// flag-repeat emits one --flag=v per element/entry instead of a single
+		// comma-joined value, for flags that reject (or misinterpret) the joined
+		// form. It doesn't fit the single-value Encoder contract, so it's handled
+		// here rather than through the registry.
+		flagRepeat := flagFormat == "repeat"
+		if tagRepeat := field.Tag.Get("flag-repeat"); tagRepeat != "" {
+			b, err := strconv.ParseBool(tagRepeat)
+			if err != nil {
+				return fmt.Errorf("BuildFlags: invalid flag-repeat %q: %s", tagRepeat, path)
 			}
-
-		case bool, int, int32, int64, float32, float64:
-			vString := fmt.Sprintf("%v", v)
-			if vString != flagEmpty {
-				flag = fmt.Sprintf("--%s=%s", flagName, vString)
-			}
-
-		case metav1.Duration:
-			vString := v.Duration.String()
-
-			// See https://github.com/kubernetes/kubernetes/issues/40783
-			// Go renders a time.Duration to `0` in <= 1.6, and `0s` in >= 1.7
-			// We force it to be `0s`, regardless of value
-			if vString == "0" {
-				vString = "0s"
-			}
-
-			if vString != flagEmpty {
-				flag = fmt.Sprintf("--%s=%s", flagName, vString)
+			flagRepeat = flagRepeat || b
+		}
+		if flagRepeat {
+			switch v := val.Interface().(type) {
+			case []string:
+				values := append([]string(nil), v...)
+				sort.Strings(values)
+				for _, s := range values {
+					flags = append(flags, fmt.Sprintf("--%s=%s", flagName, s))
+				}
+			case map[string]string:
+				var keys []string
+				for k := range v {
+					keys = append(keys, k)
+				}
+				sort.Strings(keys)
+				for _, k := range keys {
+					flags = append(flags, fmt.Sprintf("--%s=%s=%s", flagName, k, v[k]))
+				}
+			default:
+				return fmt.Errorf("BuildFlags: flag-repeat not supported for %T %s", val.Interface(), path)
 			}
+			return utils.SkipReflection
+		}
 
-		default:
-			return fmt.Errorf("BuildFlags of value type not handled: %T %s=%v", v, path, v)
+		fn, ok := encoderFor(val.Type())
+		if !ok {
+			return fmt.Errorf("BuildFlags of value type not handled: %T %s=%v", val.Interface(), path, val.Interface())
+		}
+		s, err := fn(flagName, val, field.Tag)
+		if err != nil {
+			return err
 		}
-		if flag != "" {
-			flags = append(flags, flag)
+		if s != "" {
+			flags = append(flags, fmt.Sprintf("--%s=%s", flagName, s))
 		}
 		// Nothing more to do here
 		return utils.SkipReflection
 	}
 	err := utils.ReflectRecursive(reflect.ValueOf(options), walker)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 	// Sort so that the order is stable across runs
 	sort.Strings(flags)
 
-	return strings.Join(flags, " "), nil
+	return flags, nil
 }
@@ -0,0 +1,63 @@
+/*
+Copyright 2016 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package flagbuilder
+
+import (
+	"reflect"
+	"testing"
+)
+
+type testDuration int
+
+func TestBuildFlagsWithEncodersOverridesRegistered(t *testing.T) {
+	type Options struct {
+		Custom testDuration `flag:"custom"`
+	}
+
+	ty := reflect.TypeOf(testDuration(0))
+	RegisterFlagEncoder(ty, func(name string, v reflect.Value, tag reflect.StructTag) (string, error) {
+		return "registered", nil
+	})
+	defer func() {
+		defaultEncodersMu.Lock()
+		delete(defaultEncoders, ty)
+		defaultEncodersMu.Unlock()
+	}()
+
+	options := &Options{Custom: testDuration(5)}
+
+	actual, err := BuildFlags(options)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if expected := "--custom=registered"; actual != expected {
+		t.Errorf("expected %q, got %q", expected, actual)
+	}
+
+	extra := map[reflect.Type]Encoder{
+		ty: func(name string, v reflect.Value, tag reflect.StructTag) (string, error) {
+			return "overridden", nil
+		},
+	}
+	list, err := BuildFlagsWithEncoders(options, extra)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if expected := []string{"--custom=overridden"}; !reflect.DeepEqual(list, expected) {
+		t.Errorf("expected %v, got %v", expected, list)
+	}
+}
@@ -0,0 +1,59 @@
+/*
+Copyright 2016 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package flagbuilder
+
+import (
+	"testing"
+)
+
+func TestBuildFlagsFeatureGatesMap(t *testing.T) {
+	type Options struct {
+		FeatureGates map[string]bool `flag:"feature-gates"`
+	}
+
+	options := &Options{
+		FeatureGates: map[string]bool{
+			"Zeta":  true,
+			"Alpha": false,
+		},
+	}
+
+	actual, err := BuildFlags(options)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := "--feature-gates=Alpha=false,Zeta=true"
+	if actual != expected {
+		t.Errorf("expected %q, got %q", expected, actual)
+	}
+}
+
+func TestBuildFlagsFeatureGatesMapUnknownFormat(t *testing.T) {
+	type Options struct {
+		FeatureGates map[string]bool `flag:"feature-gates" flag-format:"bogus"`
+	}
+
+	options := &Options{
+		FeatureGates: map[string]bool{"Alpha": true},
+	}
+
+	_, err := BuildFlags(options)
+	if err == nil {
+		t.Fatalf("expected error for unsupported flag-format, got none")
+	}
+}
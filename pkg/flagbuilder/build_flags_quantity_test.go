@@ -0,0 +1,48 @@
+/*
+Copyright 2016 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package flagbuilder
+
+import (
+	"testing"
+
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+func TestBuildFlagsQuantity(t *testing.T) {
+	type Options struct {
+		BufferSize  resource.Quantity  `flag:"log-json-info-buffer-size"`
+		EmptySize   *resource.Quantity `flag:"empty-size" flag-empty:"0"`
+		DefaultSize resource.Quantity  `flag:"default-size" flag-empty:"0"`
+	}
+
+	zero := resource.MustParse("0")
+	options := &Options{
+		BufferSize:  resource.MustParse("2Ki"),
+		EmptySize:   &zero,
+		DefaultSize: resource.MustParse("0"),
+	}
+
+	actual, err := BuildFlags(options)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := "--log-json-info-buffer-size=2Ki"
+	if actual != expected {
+		t.Errorf("expected %q, got %q", expected, actual)
+	}
+}
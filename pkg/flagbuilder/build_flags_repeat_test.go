@@ -0,0 +1,132 @@
+/*
+Copyright 2016 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package flagbuilder
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestBuildFlagsRepeatSlice(t *testing.T) {
+	type Options struct {
+		RuntimeConfig []string `flag:"runtime-config" flag-repeat:"true"`
+	}
+
+	options := &Options{
+		RuntimeConfig: []string{"b=2", "a=1"},
+	}
+
+	actual, err := BuildFlagsList(options)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := []string{"--runtime-config=a=1", "--runtime-config=b=2"}
+	if !reflect.DeepEqual(actual, expected) {
+		t.Errorf("expected %v, got %v", expected, actual)
+	}
+}
+
+func TestBuildFlagsRepeatMap(t *testing.T) {
+	type Options struct {
+		Labels map[string]string `flag:"node-labels" flag-format:"repeat"`
+	}
+
+	options := &Options{
+		Labels: map[string]string{"b": "2", "a": "1"},
+	}
+
+	actual, err := BuildFlagsList(options)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := []string{"--node-labels=a=1", "--node-labels=b=2"}
+	if !reflect.DeepEqual(actual, expected) {
+		t.Errorf("expected %v, got %v", expected, actual)
+	}
+}
+
+func TestBuildFlagsSliceDefaultOrderPreserved(t *testing.T) {
+	type Options struct {
+		AdmissionControl []string `flag:"admission-control"`
+	}
+
+	options := &Options{
+		AdmissionControl: []string{"Zeta", "Alpha"},
+	}
+
+	actual, err := BuildFlags(options)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := "--admission-control=Zeta,Alpha"
+	if actual != expected {
+		t.Errorf("expected %q, got %q", expected, actual)
+	}
+}
+
+func TestBuildFlagsUnknownFlagFormat(t *testing.T) {
+	type Options struct {
+		RuntimeConfig []string `flag:"runtime-config" flag-format:"repeet"`
+	}
+
+	options := &Options{
+		RuntimeConfig: []string{"a=1"},
+	}
+
+	_, err := BuildFlags(options)
+	if err == nil {
+		t.Fatalf("expected error for unrecognized flag-format, got none")
+	}
+}
+
+func TestBuildFlagsRepeatTagRejectsNonBool(t *testing.T) {
+	type Options struct {
+		RuntimeConfig []string `flag:"runtime-config" flag-repeat:"yes"`
+	}
+
+	options := &Options{
+		RuntimeConfig: []string{"a=1"},
+	}
+
+	_, err := BuildFlags(options)
+	if err == nil {
+		t.Fatalf("expected error for non-bool flag-repeat value, got none")
+	}
+}
+
+func TestBuildFlagsRepeatTagFalseDisablesRepeat(t *testing.T) {
+	type Options struct {
+		RuntimeConfig []string `flag:"runtime-config" flag-repeat:"false"`
+	}
+
+	options := &Options{
+		RuntimeConfig: []string{"b=2", "a=1"},
+	}
+
+	actual, err := BuildFlags(options)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := "--runtime-config=b=2,a=1"
+	if actual != expected {
+		t.Errorf("expected %q, got %q", expected, actual)
+	}
+}